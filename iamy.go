@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/99designs/iamy/Godeps/_workspace/src/gopkg.in/alecthomas/kingpin.v2"
+	"github.com/99designs/iamy/iamy"
 )
 
 var (
@@ -29,12 +30,41 @@ type Ui struct {
 
 func main() {
 	var (
-		debug     = kingpin.Flag("debug", "Show debugging output").Bool()
-		pull      = kingpin.Command("pull", "Syncs IAM users, groups and policies from the active AWS account to files")
-		pullDir   = pull.Flag("dir", "The directory to dump yaml files to").Default(defaultDir).Short('d').String()
-		canDelete = pull.Flag("delete", "Delete extraneous files from destination dir").Bool()
-		push      = kingpin.Command("push", "Syncs IAM users, groups and policies from files to the active AWS account")
-		pushDir   = push.Flag("dir", "The directoy to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		debug           = kingpin.Flag("debug", "Show debugging output").Bool()
+		pull            = kingpin.Command("pull", "Syncs IAM users, groups and policies from the active AWS account to files")
+		pullDir         = pull.Flag("dir", "The directory to dump yaml files to").Default(defaultDir).Short('d').String()
+		canDelete       = pull.Flag("delete", "Delete extraneous files from destination dir").Bool()
+		attachmentStyle = pull.Flag("attachment-style", "How to write managed policy attachments: inline (on each user/group/role) or separate (one file per policy)").Default("inline").Enum("inline", "separate")
+		policyFormat    = pull.Flag("policy-format", "How to write policy documents: inline-yaml or json-sidecar").Default(iamy.PolicyFormatInlineYAML).Enum(iamy.PolicyFormatInlineYAML, iamy.PolicyFormatJSONSidecar)
+		push            = kingpin.Command("push", "Syncs IAM users, groups and policies from files to the active AWS account")
+		pushDir         = push.Flag("dir", "The directoy to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		skipValidate    = push.Flag("skip-validate", "Don't run policy validation rules before pushing").Bool()
+		dryRun          = push.Flag("dry-run", "Print the changes push would make without making them").Bool()
+		pushOutput      = push.Flag("output", "Output format for the plan: text or json").Default("text").Enum("text", "json")
+
+		validate      = kingpin.Command("validate", "Lints the policy documents in the yaml files under dir against a ruleset, without talking to AWS")
+		validateDir   = validate.Flag("dir", "The directoy to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		validateRules = validate.Flag("rules", "A YAML ruleset file to validate against, instead of the bundled default ruleset").ExistingFile()
+
+		orgPull            = kingpin.Command("org-pull", "Runs pull against every account in an AWS Organization")
+		orgPullDir         = orgPull.Flag("dir", "The directory to dump yaml files to").Default(defaultDir).Short('d').String()
+		orgPullCanDelete   = orgPull.Flag("delete", "Delete extraneous files from destination dir").Bool()
+		orgPullAttachStyle = orgPull.Flag("attachment-style", "How to write managed policy attachments: inline (on each user/group/role) or separate (one file per policy)").Default("inline").Enum("inline", "separate")
+		orgPullPolicyFmt   = orgPull.Flag("policy-format", "How to write policy documents: inline-yaml or json-sidecar").Default(iamy.PolicyFormatInlineYAML).Enum(iamy.PolicyFormatInlineYAML, iamy.PolicyFormatJSONSidecar)
+		orgPullAssumeRole  = orgPull.Flag("assume-role", "The role to assume in each member account").Default("OrganizationAccountAccessRole").String()
+		orgPullConcurrency = orgPull.Flag("concurrency", "Maximum number of accounts to pull at once").Default("4").Int()
+		orgPullOU          = orgPull.Flag("ou", "Only pull accounts under this organizational unit path").String()
+		orgPullAccountTag  = orgPull.Flag("account-tag", "Only pull accounts tagged key=value").String()
+
+		orgPush            = kingpin.Command("org-push", "Runs push against every account in an AWS Organization")
+		orgPushDir         = orgPush.Flag("dir", "The directoy to load yaml files from").Default(defaultDir).Short('d').ExistingDir()
+		orgPushSkipValid   = orgPush.Flag("skip-validate", "Don't run policy validation rules before pushing").Bool()
+		orgPushDryRun      = orgPush.Flag("dry-run", "Print the changes push would make without making them").Bool()
+		orgPushOutput      = orgPush.Flag("output", "Output format for the plan: text or json").Default("text").Enum("text", "json")
+		orgPushAssumeRole  = orgPush.Flag("assume-role", "The role to assume in each member account").Default("OrganizationAccountAccessRole").String()
+		orgPushConcurrency = orgPush.Flag("concurrency", "Maximum number of accounts to push to at once").Default("4").Int()
+		orgPushOU          = orgPush.Flag("ou", "Only push accounts under this organizational unit path").String()
+		orgPushAccountTag  = orgPush.Flag("account-tag", "Only push accounts tagged key=value").String()
 	)
 
 	kingpin.Version(Version)
@@ -61,13 +91,52 @@ func main() {
 	switch cmd {
 	case push.FullCommand():
 		PushCommand(ui, PushCommandInput{
-			Dir: *pushDir,
+			Dir:          *pushDir,
+			SkipValidate: *skipValidate,
+			DryRun:       *dryRun,
+			Output:       *pushOutput,
 		})
 
 	case pull.FullCommand():
 		PullCommand(ui, PullCommandInput{
-			Dir:       *pullDir,
-			CanDelete: *canDelete,
+			Dir:             *pullDir,
+			CanDelete:       *canDelete,
+			AttachmentStyle: *attachmentStyle,
+			PolicyFormat:    *policyFormat,
+		})
+
+	case validate.FullCommand():
+		ValidateCommand(ui, ValidateCommandInput{
+			Dir:   *validateDir,
+			Rules: *validateRules,
+		})
+
+	case orgPull.FullCommand():
+		OrgPullCommand(ui, OrgPullCommandInput{
+			OrgCommandInput: OrgCommandInput{
+				Dir:         *orgPullDir,
+				AssumeRole:  *orgPullAssumeRole,
+				Concurrency: *orgPullConcurrency,
+				OU:          *orgPullOU,
+				AccountTag:  *orgPullAccountTag,
+			},
+			CanDelete:       *orgPullCanDelete,
+			AttachmentStyle: *orgPullAttachStyle,
+			PolicyFormat:    *orgPullPolicyFmt,
+		})
+
+	case orgPush.FullCommand():
+		OrgPushCommand(ui, OrgPushCommandInput{
+			OrgCommandInput: OrgCommandInput{
+				Dir:         *orgPushDir,
+				AssumeRole:  *orgPushAssumeRole,
+				Concurrency: *orgPushConcurrency,
+				OU:          *orgPushOU,
+				AccountTag:  *orgPushAccountTag,
+			},
+			SkipValidate: *orgPushSkipValid,
+			DryRun:       *orgPushDryRun,
+			Output:       *orgPushOutput,
 		})
 	}
 }
@@ -0,0 +1,542 @@
+package iamy
+
+import (
+	"fmt"
+
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/session"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/service/iam"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/service/sts"
+)
+
+// AWSIAMClient is the subset of the IAM API that iamy needs in order to
+// fetch the current state of an account and reconcile it against the
+// desired state described by YAML files. It exists so that push/pull
+// logic can be tested against a fake without talking to AWS.
+type AWSIAMClient interface {
+	// DescribeAccount identifies the account the client is authenticated
+	// against, including its alias if one is set.
+	DescribeAccount() (*Account, error)
+	FetchAccountData(account *Account) (*AccountData, error)
+
+	CreateUser(path, name string) error
+	DeleteUser(name string) error
+	CreateGroup(path, name string) error
+	DeleteGroup(name string) error
+	CreateRole(path, name string, assumeRolePolicy PolicyDocument) error
+	UpdateAssumeRolePolicy(name string, assumeRolePolicy PolicyDocument) error
+	DeleteRole(name string) error
+
+	CreatePolicy(path, name string, doc PolicyDocument) error
+	UpdatePolicy(arn string, doc PolicyDocument) error
+	DeletePolicy(arn string) error
+
+	PutUserPolicy(userName, policyName string, doc PolicyDocument) error
+	DeleteUserPolicy(userName, policyName string) error
+	PutGroupPolicy(groupName, policyName string, doc PolicyDocument) error
+	DeleteGroupPolicy(groupName, policyName string) error
+	PutRolePolicy(roleName, policyName string, doc PolicyDocument) error
+	DeleteRolePolicy(roleName, policyName string) error
+
+	AttachUserPolicy(userName, policyArn string) error
+	DetachUserPolicy(userName, policyArn string) error
+	AttachGroupPolicy(groupName, policyArn string) error
+	DetachGroupPolicy(groupName, policyArn string) error
+	AttachRolePolicy(roleName, policyArn string) error
+	DetachRolePolicy(roleName, policyArn string) error
+
+	AddUserToGroup(userName, groupName string) error
+	RemoveUserFromGroup(userName, groupName string) error
+}
+
+type awsIAMClient struct {
+	iam *iam.IAM
+	sts *sts.STS
+}
+
+// NewAWSIAMClient builds an AWSIAMClient backed by the standard AWS SDK,
+// using whatever credentials and region the given session was configured
+// with.
+func NewAWSIAMClient(sess *session.Session) AWSIAMClient {
+	return &awsIAMClient{
+		iam: iam.New(sess),
+		sts: sts.New(sess),
+	}
+}
+
+// DescribeAccount uses STS to identify the account id the client is
+// authenticated against, and IAM to look up its alias, if any.
+func (c *awsIAMClient) DescribeAccount() (*Account, error) {
+	identity, err := c.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{Id: aws.StringValue(identity.Account)}
+
+	aliases, err := c.iam.ListAccountAliases(&iam.ListAccountAliasesInput{})
+	if err != nil {
+		return nil, err
+	}
+	if len(aliases.AccountAliases) > 0 {
+		account.Alias = aws.StringValue(aliases.AccountAliases[0])
+	}
+
+	return account, nil
+}
+
+func (c *awsIAMClient) CreateUser(path, name string) error {
+	_, err := c.iam.CreateUser(&iam.CreateUserInput{
+		Path:     aws.String(path),
+		UserName: aws.String(name),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteUser(name string) error {
+	_, err := c.iam.DeleteUser(&iam.DeleteUserInput{UserName: aws.String(name)})
+	return err
+}
+
+func (c *awsIAMClient) CreateGroup(path, name string) error {
+	_, err := c.iam.CreateGroup(&iam.CreateGroupInput{
+		Path:      aws.String(path),
+		GroupName: aws.String(name),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteGroup(name string) error {
+	_, err := c.iam.DeleteGroup(&iam.DeleteGroupInput{GroupName: aws.String(name)})
+	return err
+}
+
+func (c *awsIAMClient) CreateRole(path, name string, assumeRolePolicy PolicyDocument) error {
+	_, err := c.iam.CreateRole(&iam.CreateRoleInput{
+		Path:                     aws.String(path),
+		RoleName:                 aws.String(name),
+		AssumeRolePolicyDocument: aws.String(string(assumeRolePolicy.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) UpdateAssumeRolePolicy(name string, assumeRolePolicy PolicyDocument) error {
+	_, err := c.iam.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
+		RoleName:       aws.String(name),
+		PolicyDocument: aws.String(string(assumeRolePolicy.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteRole(name string) error {
+	_, err := c.iam.DeleteRole(&iam.DeleteRoleInput{RoleName: aws.String(name)})
+	return err
+}
+
+func (c *awsIAMClient) CreatePolicy(path, name string, doc PolicyDocument) error {
+	_, err := c.iam.CreatePolicy(&iam.CreatePolicyInput{
+		Path:           aws.String(path),
+		PolicyName:     aws.String(name),
+		PolicyDocument: aws.String(string(doc.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) UpdatePolicy(arn string, doc PolicyDocument) error {
+	_, err := c.iam.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
+		PolicyArn:      aws.String(arn),
+		PolicyDocument: aws.String(string(doc.json())),
+		SetAsDefault:   aws.Bool(true),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeletePolicy(arn string) error {
+	_, err := c.iam.DeletePolicy(&iam.DeletePolicyInput{PolicyArn: aws.String(arn)})
+	return err
+}
+
+func (c *awsIAMClient) PutUserPolicy(userName, policyName string, doc PolicyDocument) error {
+	_, err := c.iam.PutUserPolicy(&iam.PutUserPolicyInput{
+		UserName:       aws.String(userName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(string(doc.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteUserPolicy(userName, policyName string) error {
+	_, err := c.iam.DeleteUserPolicy(&iam.DeleteUserPolicyInput{
+		UserName:   aws.String(userName),
+		PolicyName: aws.String(policyName),
+	})
+	return err
+}
+
+func (c *awsIAMClient) PutGroupPolicy(groupName, policyName string, doc PolicyDocument) error {
+	_, err := c.iam.PutGroupPolicy(&iam.PutGroupPolicyInput{
+		GroupName:      aws.String(groupName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(string(doc.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteGroupPolicy(groupName, policyName string) error {
+	_, err := c.iam.DeleteGroupPolicy(&iam.DeleteGroupPolicyInput{
+		GroupName:  aws.String(groupName),
+		PolicyName: aws.String(policyName),
+	})
+	return err
+}
+
+func (c *awsIAMClient) PutRolePolicy(roleName, policyName string, doc PolicyDocument) error {
+	_, err := c.iam.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(string(doc.json())),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DeleteRolePolicy(roleName, policyName string) error {
+	_, err := c.iam.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+	})
+	return err
+}
+
+func (c *awsIAMClient) AttachUserPolicy(userName, policyArn string) error {
+	_, err := c.iam.AttachUserPolicy(&iam.AttachUserPolicyInput{
+		UserName:  aws.String(userName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DetachUserPolicy(userName, policyArn string) error {
+	_, err := c.iam.DetachUserPolicy(&iam.DetachUserPolicyInput{
+		UserName:  aws.String(userName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) AttachGroupPolicy(groupName, policyArn string) error {
+	_, err := c.iam.AttachGroupPolicy(&iam.AttachGroupPolicyInput{
+		GroupName: aws.String(groupName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DetachGroupPolicy(groupName, policyArn string) error {
+	_, err := c.iam.DetachGroupPolicy(&iam.DetachGroupPolicyInput{
+		GroupName: aws.String(groupName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) AttachRolePolicy(roleName, policyArn string) error {
+	_, err := c.iam.AttachRolePolicy(&iam.AttachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) DetachRolePolicy(roleName, policyArn string) error {
+	_, err := c.iam.DetachRolePolicy(&iam.DetachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (c *awsIAMClient) AddUserToGroup(userName, groupName string) error {
+	_, err := c.iam.AddUserToGroup(&iam.AddUserToGroupInput{
+		UserName:  aws.String(userName),
+		GroupName: aws.String(groupName),
+	})
+	return err
+}
+
+func (c *awsIAMClient) RemoveUserFromGroup(userName, groupName string) error {
+	_, err := c.iam.RemoveUserFromGroup(&iam.RemoveUserFromGroupInput{
+		UserName:  aws.String(userName),
+		GroupName: aws.String(groupName),
+	})
+	return err
+}
+
+// FetchAccountData walks every user, group, role and managed policy in the
+// account, along with their inline policies, managed policy attachments and
+// group memberships, and assembles them into the same AccountData shape that
+// YamlLoadDumper.Load produces from the filesystem, so the two can be
+// diffed directly.
+func (c *awsIAMClient) FetchAccountData(account *Account) (*AccountData, error) {
+	ad := NewAccountData(account.String())
+	ad.Account = account
+
+	if err := c.fetchUsers(ad); err != nil {
+		return nil, fmt.Errorf("fetching users: %s", err)
+	}
+	if err := c.fetchGroups(ad); err != nil {
+		return nil, fmt.Errorf("fetching groups: %s", err)
+	}
+	if err := c.fetchRoles(ad); err != nil {
+		return nil, fmt.Errorf("fetching roles: %s", err)
+	}
+	if err := c.fetchPolicies(ad); err != nil {
+		return nil, fmt.Errorf("fetching policies: %s", err)
+	}
+
+	return ad, nil
+}
+
+func (c *awsIAMClient) fetchUsers(ad *AccountData) error {
+	var fetchErr error
+
+	err := c.iam.ListUsersPages(&iam.ListUsersInput{}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, u := range page.Users {
+			name := aws.StringValue(u.UserName)
+
+			var policyNames []string
+			if err := c.iam.ListUserPoliciesPages(&iam.ListUserPoliciesInput{UserName: u.UserName}, func(page *iam.ListUserPoliciesOutput, lastPage bool) bool {
+				for _, n := range page.PolicyNames {
+					policyNames = append(policyNames, aws.StringValue(n))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for user %s: %s", name, err)
+				return false
+			}
+
+			inline, err := fetchInlinePolicyDocs(policyNames, func(policyName string) (*string, error) {
+				out, err := c.iam.GetUserPolicy(&iam.GetUserPolicyInput{UserName: u.UserName, PolicyName: aws.String(policyName)})
+				if err != nil {
+					return nil, err
+				}
+				return out.PolicyDocument, nil
+			})
+			if err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for user %s: %s", name, err)
+				return false
+			}
+
+			var attached []string
+			if err := c.iam.ListAttachedUserPoliciesPages(&iam.ListAttachedUserPoliciesInput{UserName: u.UserName}, func(page *iam.ListAttachedUserPoliciesOutput, lastPage bool) bool {
+				for _, p := range page.AttachedPolicies {
+					attached = append(attached, aws.StringValue(p.PolicyArn))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching attached policies for user %s: %s", name, err)
+				return false
+			}
+
+			var groups []string
+			if err := c.iam.ListGroupsForUserPages(&iam.ListGroupsForUserInput{UserName: u.UserName}, func(page *iam.ListGroupsForUserOutput, lastPage bool) bool {
+				for _, g := range page.Groups {
+					groups = append(groups, aws.StringValue(g.GroupName))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching group memberships for user %s: %s", name, err)
+				return false
+			}
+
+			ad.addUser(User{
+				Name:           name,
+				Path:           aws.StringValue(u.Path),
+				Groups:         groups,
+				InlinePolicies: inline,
+				Policies:       attached,
+			})
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return err
+}
+
+func (c *awsIAMClient) fetchGroups(ad *AccountData) error {
+	var fetchErr error
+
+	err := c.iam.ListGroupsPages(&iam.ListGroupsInput{}, func(page *iam.ListGroupsOutput, lastPage bool) bool {
+		for _, g := range page.Groups {
+			name := aws.StringValue(g.GroupName)
+
+			var policyNames []string
+			if err := c.iam.ListGroupPoliciesPages(&iam.ListGroupPoliciesInput{GroupName: g.GroupName}, func(page *iam.ListGroupPoliciesOutput, lastPage bool) bool {
+				for _, n := range page.PolicyNames {
+					policyNames = append(policyNames, aws.StringValue(n))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for group %s: %s", name, err)
+				return false
+			}
+
+			inline, err := fetchInlinePolicyDocs(policyNames, func(policyName string) (*string, error) {
+				out, err := c.iam.GetGroupPolicy(&iam.GetGroupPolicyInput{GroupName: g.GroupName, PolicyName: aws.String(policyName)})
+				if err != nil {
+					return nil, err
+				}
+				return out.PolicyDocument, nil
+			})
+			if err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for group %s: %s", name, err)
+				return false
+			}
+
+			var attached []string
+			if err := c.iam.ListAttachedGroupPoliciesPages(&iam.ListAttachedGroupPoliciesInput{GroupName: g.GroupName}, func(page *iam.ListAttachedGroupPoliciesOutput, lastPage bool) bool {
+				for _, p := range page.AttachedPolicies {
+					attached = append(attached, aws.StringValue(p.PolicyArn))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching attached policies for group %s: %s", name, err)
+				return false
+			}
+
+			ad.addGroup(Group{
+				Name:           name,
+				Path:           aws.StringValue(g.Path),
+				InlinePolicies: inline,
+				Policies:       attached,
+			})
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return err
+}
+
+func (c *awsIAMClient) fetchRoles(ad *AccountData) error {
+	var fetchErr error
+
+	err := c.iam.ListRolesPages(&iam.ListRolesInput{}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		for _, r := range page.Roles {
+			name := aws.StringValue(r.RoleName)
+
+			doc, err := NewPolicyDocumentFromEncodedJson(aws.StringValue(r.AssumeRolePolicyDocument))
+			if err != nil {
+				fetchErr = fmt.Errorf("decoding assume role policy for role %s: %s", name, err)
+				return false
+			}
+
+			var policyNames []string
+			if err := c.iam.ListRolePoliciesPages(&iam.ListRolePoliciesInput{RoleName: r.RoleName}, func(page *iam.ListRolePoliciesOutput, lastPage bool) bool {
+				for _, n := range page.PolicyNames {
+					policyNames = append(policyNames, aws.StringValue(n))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for role %s: %s", name, err)
+				return false
+			}
+
+			inline, err := fetchInlinePolicyDocs(policyNames, func(policyName string) (*string, error) {
+				out, err := c.iam.GetRolePolicy(&iam.GetRolePolicyInput{RoleName: r.RoleName, PolicyName: aws.String(policyName)})
+				if err != nil {
+					return nil, err
+				}
+				return out.PolicyDocument, nil
+			})
+			if err != nil {
+				fetchErr = fmt.Errorf("fetching inline policies for role %s: %s", name, err)
+				return false
+			}
+
+			var attached []string
+			if err := c.iam.ListAttachedRolePoliciesPages(&iam.ListAttachedRolePoliciesInput{RoleName: r.RoleName}, func(page *iam.ListAttachedRolePoliciesOutput, lastPage bool) bool {
+				for _, p := range page.AttachedPolicies {
+					attached = append(attached, aws.StringValue(p.PolicyArn))
+				}
+				return true
+			}); err != nil {
+				fetchErr = fmt.Errorf("fetching attached policies for role %s: %s", name, err)
+				return false
+			}
+
+			ad.addRole(Role{
+				Name:                     name,
+				Path:                     aws.StringValue(r.Path),
+				AssumeRolePolicyDocument: doc,
+				InlinePolicies:           inline,
+				Policies:                 attached,
+			})
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return err
+}
+
+func (c *awsIAMClient) fetchPolicies(ad *AccountData) error {
+	var fetchErr error
+
+	err := c.iam.ListPoliciesPages(&iam.ListPoliciesInput{
+		Scope: aws.String("Local"),
+	}, func(page *iam.ListPoliciesOutput, lastPage bool) bool {
+		for _, p := range page.Policies {
+			name := aws.StringValue(p.PolicyName)
+
+			version, err := c.iam.GetPolicyVersion(&iam.GetPolicyVersionInput{
+				PolicyArn: p.Arn,
+				VersionId: p.DefaultVersionId,
+			})
+			if err != nil {
+				fetchErr = fmt.Errorf("fetching document for policy %s: %s", name, err)
+				return false
+			}
+
+			doc, err := NewPolicyDocumentFromEncodedJson(aws.StringValue(version.PolicyVersion.Document))
+			if err != nil {
+				fetchErr = fmt.Errorf("decoding document for policy %s: %s", name, err)
+				return false
+			}
+
+			ad.addPolicy(Policy{
+				Name:   name,
+				Path:   aws.StringValue(p.Path),
+				Policy: doc,
+			})
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return err
+}
+
+// fetchInlinePolicyDocs fetches the document for each named inline policy
+// via getDoc, returning them as InlinePolicy values ready to attach to a
+// User, Group or Role.
+func fetchInlinePolicyDocs(names []string, getDoc func(policyName string) (*string, error)) ([]InlinePolicy, error) {
+	policies := make([]InlinePolicy, 0, len(names))
+	for _, name := range names {
+		encoded, err := getDoc(name)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := NewPolicyDocumentFromEncodedJson(aws.StringValue(encoded))
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, InlinePolicy{Name: name, Policy: doc})
+	}
+
+	return policies, nil
+}
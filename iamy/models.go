@@ -6,19 +6,45 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/mtibben/yamljsonmap"
 )
 
+// PolicyDocument is a decoded IAM policy document. It's backed by a plain
+// Go map, which remembers nothing about the order keys first appeared in
+// the source, so encoding it can't reproduce that order. Instead its
+// MarshalJSON walks every object (at any depth, including Statement
+// entries) and emits keys in policyKeyOrder, the order AWS's own console
+// and CLI use, so a sidecar .json file diffs stably against a console
+// export; unrecognised keys sort alphabetically after the recognised
+// ones. Statement array order is preserved as-is either way.
 type PolicyDocument yamljsonmap.StringKeyMap
 
+// policyKeyOrder ranks the key names that appear in an IAM policy document
+// or statement into the order AWS's own console and CLI emit them in.
+var policyKeyOrder = map[string]int{
+	"Version":      0,
+	"Id":           1,
+	"Statement":    2,
+	"Sid":          3,
+	"Effect":       4,
+	"Principal":    5,
+	"NotPrincipal": 6,
+	"Action":       7,
+	"NotAction":    8,
+	"Resource":     9,
+	"NotResource":  10,
+	"Condition":    11,
+}
+
 func (p *PolicyDocument) Encode() string {
 	return url.QueryEscape(string(p.json()))
 }
 
 func (p PolicyDocument) json() []byte {
-	jsonBytes, err := json.Marshal(yamljsonmap.StringKeyMap(p))
+	jsonBytes, err := marshalPolicyJSON(map[string]interface{}(p))
 	if err != nil {
 		panic(err.Error())
 	}
@@ -32,10 +58,87 @@ func (p *PolicyDocument) JsonString() string {
 }
 
 func (m PolicyDocument) MarshalJSON() ([]byte, error) {
-	return json.Marshal(yamljsonmap.StringKeyMap(m))
+	return marshalPolicyJSON(map[string]interface{}(m))
+}
+
+// marshalPolicyJSON JSON-encodes v, ordering the keys of any
+// map[string]interface{} found at any depth according to policyKeyOrder
+// instead of encoding/json's default alphabetical order.
+func marshalPolicyJSON(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			ri, oki := policyKeyOrder[keys[i]]
+			rj, okj := policyKeyOrder[keys[j]]
+			switch {
+			case oki && okj:
+				return ri < rj
+			case oki:
+				return true
+			case okj:
+				return false
+			default:
+				return keys[i] < keys[j]
+			}
+		})
+
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			keyJSON, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			valJSON, err := marshalPolicyJSON(val[k])
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(keyJSON)
+			buf.WriteByte(':')
+			buf.Write(valJSON)
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+
+	case []interface{}:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			itemJSON, err := marshalPolicyJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(itemJSON)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+
+	default:
+		return json.Marshal(val)
+	}
 }
 
+// refKey is the sole key of a PolicyDocument that hasn't been resolved from
+// a json-sidecar file yet. See RefPath and YamlLoadDumper's PolicyFormat.
+const refKey = "$ref"
+
 func (m *PolicyDocument) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref string
+	if err := unmarshal(&ref); err == nil {
+		*m = PolicyDocument{refKey: ref}
+		return nil
+	}
+
 	var n yamljsonmap.StringKeyMap
 	if err := unmarshal(&n); err != nil {
 		return err
@@ -45,6 +148,25 @@ func (m *PolicyDocument) UnmarshalYAML(unmarshal func(interface{}) error) error
 	return nil
 }
 
+// RefPath returns the path of the json-sidecar file a PolicyDocument refers
+// to, and true, if it hasn't been resolved yet. A PolicyDocument loaded
+// from inline YAML, or one that's already been resolved, returns ("",
+// false).
+func (p PolicyDocument) RefPath() (string, bool) {
+	if len(p) != 1 {
+		return "", false
+	}
+	ref, ok := p[refKey].(string)
+	return ref, ok
+}
+
+// NewPolicyDocumentRef builds the placeholder PolicyDocument that
+// YamlLoadDumper.Dump writes in place of the real document when
+// PolicyFormat is "json-sidecar".
+func NewPolicyDocumentRef(path string) PolicyDocument {
+	return PolicyDocument{refKey: path}
+}
+
 func NewPolicyDocumentFromEncodedJson(encoded string) (PolicyDocument, error) {
 	jsonString, err := url.QueryUnescape(encoded)
 	if err != nil {
@@ -183,21 +305,47 @@ func (r Role) PathString() string {
 	return r.Path
 }
 
+// PolicyAttachment is a managed policy's attachments to users, groups and
+// roles, modelled as its own resource rather than as entries in each
+// principal's Policies list. It's written to {account}/attachment/{name}.yaml
+// when YamlLoadDumper is in "separate" attachment style.
+type PolicyAttachment struct {
+	Name      string   `yaml:"-"`
+	PolicyArn string   `yaml:"PolicyArn"`
+	Users     []string `yaml:"Users,omitempty"`
+	Groups    []string `yaml:"Groups,omitempty"`
+	Roles     []string `yaml:"Roles,omitempty"`
+}
+
+func (a PolicyAttachment) Type() string {
+	return "attachment"
+}
+
+func (a PolicyAttachment) NameString() string {
+	return a.Name
+}
+
+func (a PolicyAttachment) PathString() string {
+	return "/"
+}
+
 type AccountData struct {
-	Account  *Account
-	Users    []User
-	Groups   []Group
-	Roles    []Role
-	Policies []Policy
+	Account     *Account
+	Users       []User
+	Groups      []Group
+	Roles       []Role
+	Policies    []Policy
+	Attachments []PolicyAttachment
 }
 
 func NewAccountData(account string) *AccountData {
 	return &AccountData{
-		Account:  NewAccountFromString(account),
-		Users:    []User{},
-		Groups:   []Group{},
-		Roles:    []Role{},
-		Policies: []Policy{},
+		Account:     NewAccountFromString(account),
+		Users:       []User{},
+		Groups:      []Group{},
+		Roles:       []Role{},
+		Policies:    []Policy{},
+		Attachments: []PolicyAttachment{},
 	}
 }
 
@@ -217,6 +365,10 @@ func (a *AccountData) addPolicy(p Policy) {
 	a.Policies = append(a.Policies, p)
 }
 
+func (a *AccountData) addAttachment(p PolicyAttachment) {
+	a.Attachments = append(a.Attachments, p)
+}
+
 func (ad *AccountData) FindUserByName(name, path string) (bool, *User) {
 	for _, u := range ad.Users {
 		if u.Name == name && u.Path == path {
@@ -257,6 +409,117 @@ func (ad *AccountData) FindPolicyByName(name, path string) (bool, *Policy) {
 	return false, nil
 }
 
+func (ad *AccountData) FindAttachmentByName(name string) (bool, *PolicyAttachment) {
+	for _, a := range ad.Attachments {
+		if a.Name == name {
+			return true, &a
+		}
+	}
+
+	return false, nil
+}
+
+// ResolveAttachments merges any separately-stored PolicyAttachment
+// resources into the Policies list of the users, groups and roles they
+// attach to, then discards the Attachments themselves. This normalises an
+// AccountData loaded in "separate" attachment style to look like one
+// loaded in "inline" style, so the rest of iamy doesn't need to know which
+// style the YAML was written in.
+func (ad *AccountData) ResolveAttachments() {
+	for _, attachment := range ad.Attachments {
+		for _, name := range attachment.Users {
+			for i, u := range ad.Users {
+				if u.Name == name && !containsString(u.Policies, attachment.PolicyArn) {
+					ad.Users[i].Policies = append(ad.Users[i].Policies, attachment.PolicyArn)
+				}
+			}
+		}
+		for _, name := range attachment.Groups {
+			for i, g := range ad.Groups {
+				if g.Name == name && !containsString(g.Policies, attachment.PolicyArn) {
+					ad.Groups[i].Policies = append(ad.Groups[i].Policies, attachment.PolicyArn)
+				}
+			}
+		}
+		for _, name := range attachment.Roles {
+			for i, r := range ad.Roles {
+				if r.Name == name && !containsString(r.Policies, attachment.PolicyArn) {
+					ad.Roles[i].Policies = append(ad.Roles[i].Policies, attachment.PolicyArn)
+				}
+			}
+		}
+	}
+
+	ad.Attachments = []PolicyAttachment{}
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractAttachments is the inverse of ResolveAttachments: it moves every
+// policy ARN attached to a user, group or role out into its own
+// PolicyAttachment resource, one per distinct policy, and empties the
+// per-principal Policies lists. It's used when dumping YAML in "separate"
+// attachment style.
+func (ad *AccountData) ExtractAttachments() {
+	attachments := map[string]*PolicyAttachment{}
+
+	attachmentFor := func(arn string) *PolicyAttachment {
+		if pa, ok := attachments[arn]; ok {
+			return pa
+		}
+		pa := &PolicyAttachment{Name: attachmentNameFromArn(arn), PolicyArn: arn}
+		attachments[arn] = pa
+		return pa
+	}
+
+	for i, u := range ad.Users {
+		for _, arn := range u.Policies {
+			pa := attachmentFor(arn)
+			pa.Users = append(pa.Users, u.Name)
+		}
+		ad.Users[i].Policies = nil
+	}
+	for i, g := range ad.Groups {
+		for _, arn := range g.Policies {
+			pa := attachmentFor(arn)
+			pa.Groups = append(pa.Groups, g.Name)
+		}
+		ad.Groups[i].Policies = nil
+	}
+	for i, r := range ad.Roles {
+		for _, arn := range r.Policies {
+			pa := attachmentFor(arn)
+			pa.Roles = append(pa.Roles, r.Name)
+		}
+		ad.Roles[i].Policies = nil
+	}
+
+	arns := make([]string, 0, len(attachments))
+	for arn := range attachments {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+
+	ad.Attachments = make([]PolicyAttachment, 0, len(arns))
+	for _, arn := range arns {
+		ad.Attachments = append(ad.Attachments, *attachments[arn])
+	}
+}
+
+func attachmentNameFromArn(arn string) string {
+	if i := strings.LastIndex(arn, "/"); i != -1 {
+		return arn[i+1:]
+	}
+	return arn
+}
+
 func (a *Account) arnFor(key, path, name string) string {
 	return fmt.Sprintf("arn:aws:iam::%s:%s%s%s", a.Id, key, path, name)
 }
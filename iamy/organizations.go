@@ -0,0 +1,197 @@
+package iamy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/session"
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/service/organizations"
+)
+
+// OrgAccount is a member account of an AWS Organization, as enumerated by
+// the Organizations API. It's written to organization.yaml as the
+// top-level inventory of the org's accounts.
+type OrgAccount struct {
+	Id     string            `yaml:"Id"`
+	Name   string            `yaml:"Name"`
+	Email  string            `yaml:"Email"`
+	Status string            `yaml:"Status"`
+	OUPath string            `yaml:"OUPath,omitempty"`
+	Tags   map[string]string `yaml:"Tags,omitempty"`
+}
+
+// Account adapts an OrgAccount to the Account type the rest of iamy deals
+// in, using the Organizations account Name as the alias. That Name is not
+// the IAM account alias that DescribeAccount reports, so its String() can
+// differ from the directory a pull of that account writes to.
+func (o OrgAccount) Account() *Account {
+	return &Account{Id: o.Id, Alias: o.Name}
+}
+
+// MatchesOU reports whether the account's OU path is, or is nested under,
+// ou. An empty ou matches every account.
+func (o OrgAccount) MatchesOU(ou string) bool {
+	if ou == "" {
+		return true
+	}
+	return o.OUPath == ou || strings.HasPrefix(o.OUPath, ou+"/")
+}
+
+// MatchesTag reports whether the account carries a tag matching
+// "key=value". An empty keyValue matches every account.
+func (o OrgAccount) MatchesTag(keyValue string) bool {
+	if keyValue == "" {
+		return true
+	}
+
+	parts := strings.SplitN(keyValue, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	return o.Tags[parts[0]] == parts[1]
+}
+
+// organizationFile is the on-disk shape of organization.yaml: a flat list
+// of every account in the org, used as a complete snapshot of the org's
+// account inventory that CI can diff.
+type organizationFile struct {
+	Accounts []OrgAccount `yaml:"Accounts"`
+}
+
+// WriteOrganizationFile writes organization.yaml under dir, capturing the
+// id, Organizations account name, email, OU path, status and tags of every
+// account passed in. The IAM account alias is not recorded.
+func WriteOrganizationFile(dir string, accounts []OrgAccount) error {
+	return writeYamlFile(filepath.Join(dir, "organization.yaml"), organizationFile{Accounts: accounts})
+}
+
+// OrganizationsClient is the subset of the Organizations API that iamy
+// needs to enumerate member accounts and assume a role into each of them.
+type OrganizationsClient interface {
+	ListAccounts() ([]OrgAccount, error)
+	AssumeRole(account OrgAccount, roleName string) (*session.Session, error)
+}
+
+type organizationsClient struct {
+	sess *session.Session
+	orgs *organizations.Organizations
+}
+
+// NewOrganizationsClient builds an OrganizationsClient backed by the
+// standard AWS SDK, using whatever credentials the given session was
+// configured with. Those credentials must belong to the org's master
+// account (or a delegated administrator).
+func NewOrganizationsClient(sess *session.Session) OrganizationsClient {
+	return &organizationsClient{
+		sess: sess,
+		orgs: organizations.New(sess),
+	}
+}
+
+// ListAccounts returns every account in the organization, along with the
+// organizational-unit path and tags of each.
+func (c *organizationsClient) ListAccounts() ([]OrgAccount, error) {
+	accounts := []OrgAccount{}
+
+	err := c.orgs.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+		for _, a := range page.Accounts {
+			accounts = append(accounts, OrgAccount{
+				Id:     aws.StringValue(a.Id),
+				Name:   aws.StringValue(a.Name),
+				Email:  aws.StringValue(a.Email),
+				Status: aws.StringValue(a.Status),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing accounts: %s", err)
+	}
+
+	for i := range accounts {
+		ouPath, err := c.ouPathFor(accounts[i].Id)
+		if err != nil {
+			return nil, fmt.Errorf("resolving OU path for %s: %s", accounts[i].Id, err)
+		}
+		accounts[i].OUPath = ouPath
+
+		tags, err := c.tagsFor(accounts[i].Id)
+		if err != nil {
+			return nil, fmt.Errorf("resolving tags for %s: %s", accounts[i].Id, err)
+		}
+		accounts[i].Tags = tags
+	}
+
+	return accounts, nil
+}
+
+// ouPathFor walks an account's parents up to the organization root,
+// returning a "/"-joined path of organizational unit names, root first.
+func (c *organizationsClient) ouPathFor(accountId string) (string, error) {
+	var parts []string
+
+	childId := accountId
+	for {
+		parents, err := c.orgs.ListParents(&organizations.ListParentsInput{
+			ChildId: aws.String(childId),
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(parents.Parents) == 0 {
+			break
+		}
+
+		parent := parents.Parents[0]
+		if aws.StringValue(parent.Type) == organizations.ParentTypeRoot {
+			break
+		}
+
+		ou, err := c.orgs.DescribeOrganizationalUnit(&organizations.DescribeOrganizationalUnitInput{
+			OrganizationalUnitId: parent.Id,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		parts = append([]string{aws.StringValue(ou.OrganizationalUnit.Name)}, parts...)
+		childId = aws.StringValue(parent.Id)
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+func (c *organizationsClient) tagsFor(accountId string) (map[string]string, error) {
+	tags := map[string]string{}
+
+	err := c.orgs.ListTagsForResourcePages(&organizations.ListTagsForResourceInput{
+		ResourceId: aws.String(accountId),
+	}, func(page *organizations.ListTagsForResourceOutput, lastPage bool) bool {
+		for _, t := range page.Tags {
+			tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// AssumeRole returns a session authenticated as roleName in account, using
+// the master account session's credentials to call sts:AssumeRole.
+func (c *organizationsClient) AssumeRole(account OrgAccount, roleName string) (*session.Session, error) {
+	arn := fmt.Sprintf("arn:aws:iam::%s:role/%s", account.Id, roleName)
+
+	creds := stscreds.NewCredentials(c.sess, arn)
+
+	return session.NewSession(&aws.Config{Credentials: creds})
+}
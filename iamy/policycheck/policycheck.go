@@ -0,0 +1,318 @@
+// Package policycheck implements a small rule engine for linting IAM policy
+// documents before they are pushed to AWS. Rules are themselves expressed as
+// data (a YAML ruleset of enforcements) rather than Go code, so new checks
+// can be added without a recompile.
+package policycheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/99designs/iamy/Godeps/_workspace/src/gopkg.in/yaml.v2"
+	"github.com/99designs/iamy/iamy"
+)
+
+// Condition is a single JSONPath-like selector into a policy statement plus
+// the predicate that the selected value is checked against. Selectors are
+// dot-separated, with a `[]` suffix on a segment meaning "iterate this array
+// and evaluate the rest of the path against each element", e.g.
+// "Statement[].Action".
+type Condition struct {
+	Selector    string      `yaml:"selector"`
+	Equals      interface{} `yaml:"equals,omitempty"`
+	NotContains interface{} `yaml:"notContains,omitempty"`
+	Matches     string      `yaml:"matches,omitempty"`
+	Required    *bool       `yaml:"required,omitempty"`
+}
+
+// Enforcement is a named rule made up of one or more Conditions. All of its
+// Conditions must hold against the same statement for the enforcement to be
+// considered violated by that statement.
+type Enforcement struct {
+	Name    string      `yaml:"name"`
+	Message string      `yaml:"message"`
+	Match   []Condition `yaml:"match"`
+}
+
+// Ruleset is the on-disk representation of a policy-of-policies file: a
+// flat list of enforcements to run against every policy document.
+type Ruleset struct {
+	Rules []Enforcement `yaml:"rules"`
+}
+
+// Violation describes a single enforcement failing against a single
+// statement in a policy document attached to an AWS resource.
+type Violation struct {
+	Rule           string
+	ResourceArn    string
+	StatementIndex int
+	Message        string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("[%s] %s (statement %d): %s", v.Rule, v.ResourceArn, v.StatementIndex, v.Message)
+}
+
+// Validator runs a Ruleset against the policy documents found in an
+// iamy.AccountData.
+type Validator struct {
+	Ruleset Ruleset
+}
+
+// NewValidator builds a Validator from raw YAML ruleset bytes.
+func NewValidator(rulesetYaml []byte) (*Validator, error) {
+	var rs Ruleset
+	if err := yaml.Unmarshal(rulesetYaml, &rs); err != nil {
+		return nil, err
+	}
+	return &Validator{Ruleset: rs}, nil
+}
+
+// Validate walks every PolicyDocument, Role.AssumeRolePolicyDocument and
+// InlinePolicy.Policy in the given account and returns every violation
+// found, in no particular order.
+func (v *Validator) Validate(ad iamy.AccountData) ([]Violation, error) {
+	var violations []Violation
+
+	check := func(arn string, doc iamy.PolicyDocument) error {
+		if doc == nil {
+			return nil
+		}
+		generic, err := decodePolicyDocument(doc)
+		if err != nil {
+			return fmt.Errorf("decoding policy document for %s: %s", arn, err)
+		}
+		violations = append(violations, v.checkDocument(arn, generic)...)
+		return nil
+	}
+
+	for _, p := range ad.Policies {
+		if err := check(iamy.Arn(p, ad.Account), p.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range ad.Roles {
+		if err := check(iamy.Arn(r, ad.Account), r.AssumeRolePolicyDocument); err != nil {
+			return nil, err
+		}
+		for _, ip := range r.InlinePolicies {
+			if err := check(inlinePolicyArn(iamy.Arn(r, ad.Account), ip.Name), ip.Policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, u := range ad.Users {
+		for _, ip := range u.InlinePolicies {
+			if err := check(inlinePolicyArn(iamy.Arn(u, ad.Account), ip.Name), ip.Policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, g := range ad.Groups {
+		for _, ip := range g.InlinePolicies {
+			if err := check(inlinePolicyArn(iamy.Arn(g, ad.Account), ip.Name), ip.Policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+func inlinePolicyArn(principalArn, inlineName string) string {
+	return fmt.Sprintf("%s:inline-policy/%s", principalArn, inlineName)
+}
+
+func (v *Validator) checkDocument(arn string, doc interface{}) []Violation {
+	var violations []Violation
+
+	for _, rule := range v.Ruleset.Rules {
+		// matched[statementIndex] is the set of condition indices satisfied
+		// for that statement, not a raw count of selections — a selector
+		// like "Statement[].Action[]" can yield several matching selections
+		// for a single condition, and that must still only count once.
+		matched := map[int]map[int]bool{}
+		total := len(rule.Match)
+
+		for condIdx, cond := range rule.Match {
+			for _, sel := range selectPath(doc, cond.Selector) {
+				if cond.isSatisfiedBy(sel.Value) {
+					if matched[sel.StatementIndex] == nil {
+						matched[sel.StatementIndex] = map[int]bool{}
+					}
+					matched[sel.StatementIndex][condIdx] = true
+				}
+			}
+		}
+
+		for idx, conds := range matched {
+			if len(conds) == total {
+				violations = append(violations, Violation{
+					Rule:           rule.Name,
+					ResourceArn:    arn,
+					StatementIndex: idx,
+					Message:        rule.Message,
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+func (c Condition) isSatisfiedBy(value interface{}) bool {
+	switch {
+	case c.Required != nil:
+		return valuePresent(value) == *c.Required
+	case c.Equals != nil:
+		return valueEquals(value, c.Equals)
+	case c.NotContains != nil:
+		return !valueEquals(value, c.NotContains) && !valueContains(value, c.NotContains)
+	case c.Matches != "":
+		return valueMatches(value, c.Matches)
+	default:
+		return false
+	}
+}
+
+func valuePresent(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+// valueEquals compares a selected field to an expected value, treating a
+// single-element list the same as its scalar (AWS policies allow both
+// "Action": "s3:Get*" and "Action": ["s3:Get*"]).
+func valueEquals(value, expected interface{}) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if fmt.Sprintf("%v", item) == fmt.Sprintf("%v", expected) {
+				return true
+			}
+		}
+		return false
+	}
+	return fmt.Sprintf("%v", value) == fmt.Sprintf("%v", expected)
+}
+
+func valueContains(value, expected interface{}) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	return strings.Contains(s, fmt.Sprintf("%v", expected))
+}
+
+func valueMatches(value interface{}, pattern string) bool {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+
+	strs := []string{}
+	switch v := value.(type) {
+	case string:
+		strs = append(strs, v)
+	case []interface{}:
+		for _, item := range v {
+			strs = append(strs, fmt.Sprintf("%v", item))
+		}
+	default:
+		return false
+	}
+
+	for _, s := range strs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+type selection struct {
+	StatementIndex int
+	Value          interface{}
+}
+
+// selectPath evaluates a dot-separated, JSONPath-like selector (e.g.
+// "Statement[].Action") against a decoded policy document, returning one
+// selection per value reached. The statement index is carried through
+// from the first "[]" segment encountered (conventionally "Statement[]"),
+// which lets callers group multiple selections back to the statement they
+// came from.
+func selectPath(doc interface{}, selector string) []selection {
+	segments := strings.Split(selector, ".")
+	return evalSegments(doc, segments, 0, false)
+}
+
+func evalSegments(node interface{}, segments []string, idx int, indexed bool) []selection {
+	if len(segments) == 0 {
+		return []selection{{StatementIndex: idx, Value: node}}
+	}
+
+	seg := segments[0]
+	rest := segments[1:]
+	isArray := strings.HasSuffix(seg, "[]")
+	key := strings.TrimSuffix(seg, "[]")
+
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	val, present := m[key]
+	if !present {
+		if len(rest) == 0 {
+			return []selection{{StatementIndex: idx, Value: nil}}
+		}
+		return nil
+	}
+
+	if !isArray {
+		return evalSegments(val, rest, idx, indexed)
+	}
+
+	list, ok := val.([]interface{})
+	if !ok {
+		// A lone statement is valid JSON without being wrapped in an array.
+		list = []interface{}{val}
+	}
+
+	var out []selection
+	for i, item := range list {
+		itemIdx := idx
+		if !indexed {
+			itemIdx = i
+		}
+		out = append(out, evalSegments(item, rest, itemIdx, true)...)
+	}
+	return out
+}
+
+func decodePolicyDocument(doc iamy.PolicyDocument) (interface{}, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
@@ -0,0 +1,72 @@
+package policycheck
+
+// DefaultRuleset is a starter set of enforcements covering the most common
+// IAM findings. It's used by `iamy validate` when no `--rules` file is
+// given, and is a reasonable base to copy and extend.
+const DefaultRuleset = `
+rules:
+  - name: full-wildcard-statement
+    message: >-
+      Statement allows Action:* on Resource:* with Effect:Allow, granting
+      unrestricted access to the account.
+    match:
+      - selector: Statement[].Effect
+        equals: Allow
+      - selector: Statement[].Action
+        equals: "*"
+      - selector: Statement[].Resource
+        equals: "*"
+
+  - name: wildcard-iam-actions
+    message: >-
+      Statement allows iam:* actions on Resource:*, which can be used to
+      escalate privileges.
+    match:
+      - selector: Statement[].Effect
+        equals: Allow
+      - selector: Statement[].Action
+        matches: "^iam:\\*$"
+      - selector: Statement[].Resource
+        equals: "*"
+
+  - name: wildcard-assume-role
+    message: >-
+      Statement allows sts:AssumeRole on Resource:*, letting the principal
+      assume any role in the account.
+    match:
+      - selector: Statement[].Effect
+        equals: Allow
+      - selector: Statement[].Action
+        equals: "sts:AssumeRole"
+      - selector: Statement[].Resource
+        equals: "*"
+
+  - name: privileged-action-missing-condition
+    message: >-
+      Statement grants a privileged iam action without a Condition block
+      restricting when it applies.
+    match:
+      - selector: Statement[].Effect
+        equals: Allow
+      - selector: Statement[].Action
+        matches: "^iam:.*$"
+      - selector: Statement[].Condition
+        required: false
+
+  - name: passrole-without-resource-constraint
+    message: >-
+      Statement grants iam:PassRole on Resource:*. Scope PassRole down to
+      the specific role ARNs that need to be passed.
+    match:
+      - selector: Statement[].Effect
+        equals: Allow
+      - selector: Statement[].Action
+        equals: "iam:PassRole"
+      - selector: Statement[].Resource
+        equals: "*"
+`
+
+// NewDefaultValidator builds a Validator using DefaultRuleset.
+func NewDefaultValidator() (*Validator, error) {
+	return NewValidator([]byte(DefaultRuleset))
+}
@@ -2,11 +2,14 @@ package iamy
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"text/template"
 
 	"github.com/99designs/iamy/Godeps/_workspace/src/gopkg.in/yaml.v2"
@@ -14,13 +17,26 @@ import (
 
 var Yaml = YamlLoadDumper{
 	pathTemplate: "{{.Account}}/{{.Resource.Type}}{{.Resource.Path}}{{.Resource.Name}}.yaml",
-	pathRegex:    regexp.MustCompile(`^(?P<account>.+)/(?P<entity>(user|group|policy|role))(?P<path>.*/)(?P<name>.+)\.yaml$`),
+	pathRegex:    regexp.MustCompile(`^(?P<account>.+)/(?P<entity>(user|group|policy|role|attachment))(?P<path>.*/)(?P<name>.+)\.yaml$`),
 }
 
+// Policy document storage formats for YamlLoadDumper.PolicyFormat.
+const (
+	PolicyFormatInlineYAML  = "inline-yaml"
+	PolicyFormatJSONSidecar = "json-sidecar"
+)
+
 type YamlLoadDumper struct {
 	pathTemplate string
 	pathRegex    *regexp.Regexp
 	Dir          string
+
+	// PolicyFormat controls how Dump writes PolicyDocument values: inline
+	// as YAML (the default, PolicyFormatInlineYAML) or as a $ref to a
+	// companion .json file (PolicyFormatJSONSidecar). Load resolves $refs
+	// regardless of this setting, so a tree can be read no matter which
+	// format it was written in.
+	PolicyFormat string
 }
 
 func (a *YamlLoadDumper) getFilesRecursively() ([]string, error) {
@@ -89,6 +105,9 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 				}
 				u.Name = name
 				u.Path = path
+				if u.InlinePolicies, err = a.resolveInlinePolicyRefs(accountid, u.InlinePolicies); err != nil {
+					return nil, err
+				}
 				accounts[accountid].addUser(u)
 			case "group":
 				g := Group{}
@@ -98,6 +117,9 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 				}
 				g.Name = name
 				g.Path = path
+				if g.InlinePolicies, err = a.resolveInlinePolicyRefs(accountid, g.InlinePolicies); err != nil {
+					return nil, err
+				}
 				accounts[accountid].addGroup(g)
 			case "role":
 				r := Role{}
@@ -107,6 +129,12 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 				}
 				r.Name = name
 				r.Path = path
+				if r.AssumeRolePolicyDocument, err = a.resolvePolicyDocRef(accountid, r.AssumeRolePolicyDocument); err != nil {
+					return nil, err
+				}
+				if r.InlinePolicies, err = a.resolveInlinePolicyRefs(accountid, r.InlinePolicies); err != nil {
+					return nil, err
+				}
 				accounts[accountid].addRole(r)
 			case "policy":
 				p := Policy{}
@@ -116,7 +144,18 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 				}
 				p.Name = name
 				p.Path = path
+				if p.Policy, err = a.resolvePolicyDocRef(accountid, p.Policy); err != nil {
+					return nil, err
+				}
 				accounts[accountid].addPolicy(p)
+			case "attachment":
+				pa := PolicyAttachment{}
+				err := a.unmarshalYamlFile(fp, &pa)
+				if err != nil {
+					return nil, err
+				}
+				pa.Name = name
+				accounts[accountid].addAttachment(pa)
 			default:
 				panic("Unexpected entity")
 			}
@@ -127,12 +166,47 @@ func (a *YamlLoadDumper) Load() ([]AccountData, error) {
 
 	accts := []AccountData{}
 	for _, a := range accounts {
+		a.ResolveAttachments()
 		accts = append(accts, *a)
 	}
 
 	return accts, nil
 }
 
+// resolvePolicyDocRef replaces a PolicyDocument that's a $ref with the
+// document loaded from the json-sidecar file it points to, relative to the
+// account's directory. A PolicyDocument that isn't a ref is returned
+// unchanged.
+func (f *YamlLoadDumper) resolvePolicyDocRef(accountId string, doc PolicyDocument) (PolicyDocument, error) {
+	ref, ok := doc.RefPath()
+	if !ok {
+		return doc, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(f.Dir, accountId, ref))
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved PolicyDocument
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", ref, err)
+	}
+
+	return resolved, nil
+}
+
+func (f *YamlLoadDumper) resolveInlinePolicyRefs(accountId string, policies []InlinePolicy) ([]InlinePolicy, error) {
+	for i, ip := range policies {
+		resolved, err := f.resolvePolicyDocRef(accountId, ip.Policy)
+		if err != nil {
+			return nil, err
+		}
+		policies[i].Policy = resolved
+	}
+	return policies, nil
+}
+
 func (f *YamlLoadDumper) Dump(accountData *AccountData, canDelete bool) error {
 	destDir := filepath.Join(f.Dir, accountData.Account.String())
 	log.Println("Dumping YAML IAM data to", f.Dir)
@@ -167,6 +241,12 @@ func (f *YamlLoadDumper) Dump(accountData *AccountData, canDelete bool) error {
 		}
 	}
 
+	for _, attachment := range accountData.Attachments {
+		if err := f.writeAttachment(accountData.Account, attachment); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -178,6 +258,11 @@ func (f *YamlLoadDumper) writeUser(a *Account, u User) error {
 	if err != nil {
 		return err
 	}
+
+	if u.InlinePolicies, err = f.externalizeInlinePolicies(a, path, u.InlinePolicies); err != nil {
+		return err
+	}
+
 	return writeYamlFile(filepath.Join(f.Dir, path), u)
 }
 
@@ -203,6 +288,11 @@ func (f *YamlLoadDumper) writeGroup(a *Account, g Group) error {
 	if err != nil {
 		return err
 	}
+
+	if g.InlinePolicies, err = f.externalizeInlinePolicies(a, path, g.InlinePolicies); err != nil {
+		return err
+	}
+
 	return writeYamlFile(filepath.Join(f.Dir, path), g)
 }
 
@@ -214,6 +304,11 @@ func (f *YamlLoadDumper) writePolicy(a *Account, p Policy) error {
 	if err != nil {
 		return err
 	}
+
+	if p.Policy, err = f.externalizePolicyDoc(a, path, p.Policy); err != nil {
+		return err
+	}
+
 	return writeYamlFile(filepath.Join(f.Dir, path), p)
 }
 
@@ -225,9 +320,91 @@ func (f *YamlLoadDumper) writeRole(a *Account, r Role) error {
 	if err != nil {
 		return err
 	}
+
+	if r.AssumeRolePolicyDocument, err = f.externalizePolicyDoc(a, path, r.AssumeRolePolicyDocument); err != nil {
+		return err
+	}
+
+	if r.InlinePolicies, err = f.externalizeInlinePolicies(a, path, r.InlinePolicies); err != nil {
+		return err
+	}
+
 	return writeYamlFile(filepath.Join(f.Dir, path), r)
 }
 
+// externalizePolicyDoc writes doc to a .json file alongside entityPath (the
+// entity's own .yaml path, account-prefixed) and returns a $ref to it, when
+// f.PolicyFormat is PolicyFormatJSONSidecar. Otherwise it returns doc
+// unchanged.
+func (f *YamlLoadDumper) externalizePolicyDoc(a *Account, entityPath string, doc PolicyDocument) (PolicyDocument, error) {
+	if f.PolicyFormat != PolicyFormatJSONSidecar {
+		return doc, nil
+	}
+
+	fullPath := strings.TrimSuffix(entityPath, ".yaml") + ".json"
+	if err := f.writePolicyDocFile(fullPath, doc); err != nil {
+		return nil, err
+	}
+
+	return NewPolicyDocumentRef(accountRelativePath(a, fullPath)), nil
+}
+
+// externalizeInlinePolicies writes each inline policy's document to its own
+// .json file under a directory named after entityPath (the parent entity's
+// .yaml path, account-prefixed), and returns the InlinePolicies with their
+// Policy fields replaced by $refs, when f.PolicyFormat is
+// PolicyFormatJSONSidecar. Otherwise it returns policies unchanged.
+func (f *YamlLoadDumper) externalizeInlinePolicies(a *Account, entityPath string, policies []InlinePolicy) ([]InlinePolicy, error) {
+	if f.PolicyFormat != PolicyFormatJSONSidecar || len(policies) == 0 {
+		return policies, nil
+	}
+
+	dir := strings.TrimSuffix(entityPath, ".yaml")
+
+	out := make([]InlinePolicy, len(policies))
+	for i, ip := range policies {
+		fullPath := filepath.Join(dir, ip.Name+".json")
+		if err := f.writePolicyDocFile(fullPath, ip.Policy); err != nil {
+			return nil, err
+		}
+		out[i] = InlinePolicy{Name: ip.Name, Policy: NewPolicyDocumentRef(accountRelativePath(a, fullPath))}
+	}
+
+	return out, nil
+}
+
+// accountRelativePath strips an account's directory prefix from path, so
+// that a $ref stays valid if the whole tree is moved or copied into
+// another account's directory.
+func accountRelativePath(a *Account, path string) string {
+	return strings.TrimPrefix(path, a.String()+"/")
+}
+
+// writePolicyDocFile writes doc's JSON encoding to relPath, account-prefixed,
+// creating any parent directories needed. As with any PolicyDocument, object
+// keys come out in policyKeyOrder (AWS's own console/CLI ordering) rather
+// than their original source order, and Statement order is preserved.
+func (f *YamlLoadDumper) writePolicyDocFile(relPath string, doc PolicyDocument) error {
+	path := filepath.Join(f.Dir, relPath)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(doc.JsonString()+"\n"), 0666)
+}
+
+func (f *YamlLoadDumper) writeAttachment(a *Account, attachment PolicyAttachment) error {
+	path, err := renderPath(f.pathTemplate, map[string]interface{}{
+		"Account":  a,
+		"Resource": attachment,
+	})
+	if err != nil {
+		return err
+	}
+	return writeYamlFile(filepath.Join(f.Dir, path), attachment)
+}
+
 func renderPath(tpl string, context map[string]interface{}) (string, error) {
 	t, err := template.New("tpl").Parse(tpl)
 	if err != nil {
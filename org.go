@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/session"
+	"github.com/99designs/iamy/iamy"
+)
+
+// OrgCommandInput holds the flags shared by org-pull and org-push: which
+// member accounts of the organization to operate on, and how to get
+// credentials into each of them.
+type OrgCommandInput struct {
+	Dir         string
+	AssumeRole  string
+	Concurrency int
+	OU          string
+	AccountTag  string
+}
+
+type OrgPullCommandInput struct {
+	OrgCommandInput
+	CanDelete       bool
+	AttachmentStyle string
+	PolicyFormat    string
+}
+
+type OrgPushCommandInput struct {
+	OrgCommandInput
+	SkipValidate bool
+	DryRun       bool
+	Output       string
+}
+
+// orgAccountError pairs a failure with the account it happened in, so that
+// a problem in one member account doesn't abort the run for the rest of
+// the organization.
+type orgAccountError struct {
+	Account iamy.OrgAccount
+	Err     error
+}
+
+func (e orgAccountError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", e.Account.Name, e.Account.Id, e.Err)
+}
+
+// OrgPullCommand runs PullCommand's logic against every account in the AWS
+// Organization the active credentials administer, writing each account's
+// IAM state into its own {Dir}/{alias}-{id}/ subdirectory.
+func OrgPullCommand(ui Ui, input OrgPullCommandInput) {
+	forEachOrgAccount(ui, input.OrgCommandInput, func(client iamy.AWSIAMClient, account iamy.OrgAccount) error {
+		return pullAccount(client, PullCommandInput{
+			Dir:             input.Dir,
+			CanDelete:       input.CanDelete,
+			AttachmentStyle: input.AttachmentStyle,
+			PolicyFormat:    input.PolicyFormat,
+		})
+	})
+}
+
+// OrgPushCommand runs PushCommand's logic against every account in the AWS
+// Organization the active credentials administer, loading each account's
+// YAML from its own {Dir}/{alias}-{id}/ subdirectory.
+func OrgPushCommand(ui Ui, input OrgPushCommandInput) {
+	forEachOrgAccount(ui, input.OrgCommandInput, func(client iamy.AWSIAMClient, account iamy.OrgAccount) error {
+		loader := iamy.Yaml
+		loader.Dir = input.Dir
+
+		localAccounts, err := loader.Load()
+		if err != nil {
+			return err
+		}
+
+		local, err := findLocalAccount(localAccounts, account.Id)
+		if err != nil {
+			return err
+		}
+
+		pushInput := PushCommandInput{
+			Dir:          loader.Dir,
+			SkipValidate: input.SkipValidate,
+			DryRun:       input.DryRun,
+			Output:       input.Output,
+		}
+
+		return pushAccount(ui, client, *local, pushInput)
+	})
+}
+
+// forEachOrgAccount enumerates the accounts in the organization, writes
+// organization.yaml as a snapshot of the full account inventory, then
+// assumes input.AssumeRole in every account matching input.OU and
+// input.AccountTag (up to input.Concurrency at a time) and runs fn against
+// it. A failure in one account is collected and reported, not fatal to the
+// others; the run exits non-zero if any account failed.
+func forEachOrgAccount(ui Ui, input OrgCommandInput, fn func(client iamy.AWSIAMClient, account iamy.OrgAccount) error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+	orgClient := iamy.NewOrganizationsClient(sess)
+
+	accounts, err := orgClient.ListAccounts()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	if err := iamy.WriteOrganizationFile(input.Dir, accounts); err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	filtered := make([]iamy.OrgAccount, 0, len(accounts))
+	for _, a := range accounts {
+		if a.MatchesOU(input.OU) && a.MatchesTag(input.AccountTag) {
+			filtered = append(filtered, a)
+		}
+	}
+
+	concurrency := input.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sem  = make(chan struct{}, concurrency)
+		errs []orgAccountError
+	)
+
+	for _, account := range filtered {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(account iamy.OrgAccount) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := processOrgAccount(orgClient, input.AssumeRole, account, fn); err != nil {
+				mu.Lock()
+				errs = append(errs, orgAccountError{Account: account, Err: err})
+				mu.Unlock()
+			}
+		}(account)
+	}
+	wg.Wait()
+
+	ui.Printf("Processed %d account(s), %d failed.\n", len(filtered), len(errs))
+	for _, e := range errs {
+		ui.Error.Println(e)
+	}
+	if len(errs) > 0 {
+		ui.Exit(1)
+	}
+}
+
+func processOrgAccount(orgClient iamy.OrganizationsClient, roleName string, account iamy.OrgAccount, fn func(client iamy.AWSIAMClient, account iamy.OrgAccount) error) error {
+	accountSess, err := orgClient.AssumeRole(account, roleName)
+	if err != nil {
+		return fmt.Errorf("assuming role %s: %s", roleName, err)
+	}
+
+	return fn(iamy.NewAWSIAMClient(accountSess), account)
+}
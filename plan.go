@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/99designs/iamy/iamy"
+)
+
+// ChangeAction is the kind of mutation a Change represents.
+type ChangeAction string
+
+const (
+	ActionCreate ChangeAction = "create"
+	ActionUpdate ChangeAction = "update"
+	ActionDelete ChangeAction = "delete"
+)
+
+// Change is a single create/update/delete operation needed to bring AWS in
+// line with the local YAML, in the stable schema used by `push --output
+// json`.
+type Change struct {
+	Action     ChangeAction `json:"action"`
+	Type       string       `json:"type"`
+	Path       string       `json:"path"`
+	Name       string       `json:"name"`
+	Before     interface{}  `json:"before,omitempty"`
+	After      interface{}  `json:"after,omitempty"`
+	PolicyDiff string       `json:"policyDiff,omitempty"`
+}
+
+// Plan is the full set of changes needed to reconcile one account, in the
+// schema emitted by `push --dry-run --output json`.
+type Plan struct {
+	Account string   `json:"account"`
+	Changes []Change `json:"changes"`
+}
+
+// Planner computes the changes needed to reconcile a local (YAML) view of
+// an account with its remote (AWS) state. It holds no AWS client itself -
+// PushCommand is responsible for turning a Plan's changes into API calls.
+type Planner struct{}
+
+// Plan diffs local against remote and returns every create/update/delete
+// needed to make remote match local.
+func (Planner) Plan(local, remote iamy.AccountData) (Plan, error) {
+	plan := Plan{Account: local.Account.String()}
+
+	for _, u := range local.Users {
+		if found, _ := remote.FindUserByName(u.Name, u.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: "user", Path: u.Path, Name: u.Name, After: u})
+		}
+	}
+	for _, u := range remote.Users {
+		if found, _ := local.FindUserByName(u.Name, u.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: "user", Path: u.Path, Name: u.Name, Before: u})
+		}
+	}
+
+	for _, g := range local.Groups {
+		if found, _ := remote.FindGroupByName(g.Name, g.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: "group", Path: g.Path, Name: g.Name, After: g})
+		}
+	}
+	for _, g := range remote.Groups {
+		if found, _ := local.FindGroupByName(g.Name, g.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: "group", Path: g.Path, Name: g.Name, Before: g})
+		}
+	}
+
+	if err := planRoles(&plan, local, remote); err != nil {
+		return plan, err
+	}
+	if err := planPolicies(&plan, local, remote); err != nil {
+		return plan, err
+	}
+
+	for _, u := range local.Users {
+		found, existing := remote.FindUserByName(u.Name, u.Path)
+		var remotePolicies []iamy.InlinePolicy
+		if found {
+			remotePolicies = existing.InlinePolicies
+		}
+		if err := planInlinePolicies(&plan, "user", u.Path, u.Name, u.InlinePolicies, remotePolicies); err != nil {
+			return plan, err
+		}
+	}
+	for _, g := range local.Groups {
+		found, existing := remote.FindGroupByName(g.Name, g.Path)
+		var remotePolicies []iamy.InlinePolicy
+		if found {
+			remotePolicies = existing.InlinePolicies
+		}
+		if err := planInlinePolicies(&plan, "group", g.Path, g.Name, g.InlinePolicies, remotePolicies); err != nil {
+			return plan, err
+		}
+	}
+	for _, r := range local.Roles {
+		found, existing := remote.FindRoleByName(r.Name, r.Path)
+		var remotePolicies []iamy.InlinePolicy
+		if found {
+			remotePolicies = existing.InlinePolicies
+		}
+		if err := planInlinePolicies(&plan, "role", r.Path, r.Name, r.InlinePolicies, remotePolicies); err != nil {
+			return plan, err
+		}
+	}
+
+	planGroupMemberships(&plan, local, remote)
+
+	for _, u := range local.Users {
+		found, existing := remote.FindUserByName(u.Name, u.Path)
+		var remotePolicies []string
+		if found {
+			remotePolicies = existing.Policies
+		}
+		planPolicyAttachments(&plan, "user", u.Name, u.Policies, remotePolicies)
+	}
+	for _, g := range local.Groups {
+		found, existing := remote.FindGroupByName(g.Name, g.Path)
+		var remotePolicies []string
+		if found {
+			remotePolicies = existing.Policies
+		}
+		planPolicyAttachments(&plan, "group", g.Name, g.Policies, remotePolicies)
+	}
+	for _, r := range local.Roles {
+		found, existing := remote.FindRoleByName(r.Name, r.Path)
+		var remotePolicies []string
+		if found {
+			remotePolicies = existing.Policies
+		}
+		planPolicyAttachments(&plan, "role", r.Name, r.Policies, remotePolicies)
+	}
+
+	return plan, nil
+}
+
+func planRoles(plan *Plan, local, remote iamy.AccountData) error {
+	for _, r := range local.Roles {
+		found, existing := remote.FindRoleByName(r.Name, r.Path)
+		if !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: "role", Path: r.Path, Name: r.Name, After: r})
+			continue
+		}
+
+		diff, changed, err := diffPolicyDocuments(existing.AssumeRolePolicyDocument, r.AssumeRolePolicyDocument)
+		if err != nil {
+			return err
+		}
+		if changed {
+			plan.Changes = append(plan.Changes, Change{
+				Action: ActionUpdate, Type: "role", Path: r.Path, Name: r.Name,
+				Before: existing.AssumeRolePolicyDocument, After: r.AssumeRolePolicyDocument, PolicyDiff: diff,
+			})
+		}
+	}
+	for _, r := range remote.Roles {
+		if found, _ := local.FindRoleByName(r.Name, r.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: "role", Path: r.Path, Name: r.Name, Before: r})
+		}
+	}
+	return nil
+}
+
+func planPolicies(plan *Plan, local, remote iamy.AccountData) error {
+	for _, p := range local.Policies {
+		found, existing := remote.FindPolicyByName(p.Name, p.Path)
+		if !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: "policy", Path: p.Path, Name: p.Name, After: p})
+			continue
+		}
+
+		diff, changed, err := diffPolicyDocuments(existing.Policy, p.Policy)
+		if err != nil {
+			return err
+		}
+		if changed {
+			plan.Changes = append(plan.Changes, Change{
+				Action: ActionUpdate, Type: "policy", Path: p.Path, Name: p.Name,
+				Before: existing.Policy, After: p.Policy, PolicyDiff: diff,
+			})
+		}
+	}
+	for _, p := range remote.Policies {
+		if found, _ := local.FindPolicyByName(p.Name, p.Path); !found {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: "policy", Path: p.Path, Name: p.Name, Before: p})
+		}
+	}
+	return nil
+}
+
+// planInlinePolicies diffs one principal's inline policies, appending any
+// create/update/delete changes found to plan.
+func planInlinePolicies(plan *Plan, resourceType, path, name string, localPolicies, remotePolicies []iamy.InlinePolicy) error {
+	inlineType := resourceType + "-inline-policy"
+
+	for _, lp := range localPolicies {
+		rp := findInlinePolicy(remotePolicies, lp.Name)
+		if rp == nil {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: inlineType, Path: path, Name: name + "/" + lp.Name, After: lp.Policy})
+			continue
+		}
+		diff, changed, err := diffPolicyDocuments(rp.Policy, lp.Policy)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		plan.Changes = append(plan.Changes, Change{Action: ActionUpdate, Type: inlineType, Path: path, Name: name + "/" + lp.Name, Before: rp.Policy, After: lp.Policy, PolicyDiff: diff})
+	}
+	for _, rp := range remotePolicies {
+		if findInlinePolicy(localPolicies, rp.Name) == nil {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: inlineType, Path: path, Name: name + "/" + rp.Name, Before: rp.Policy})
+		}
+	}
+	return nil
+}
+
+func findInlinePolicy(policies []iamy.InlinePolicy, name string) *iamy.InlinePolicy {
+	for i := range policies {
+		if policies[i].Name == name {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// planGroupMemberships diffs which users belong to which groups. The
+// membership's user is carried in Path and its group in Name, since a
+// membership doesn't have an IAM path of its own.
+func planGroupMemberships(plan *Plan, local, remote iamy.AccountData) {
+	type membership struct{ user, group string }
+
+	localMemberships := map[membership]bool{}
+	for _, u := range local.Users {
+		for _, g := range u.Groups {
+			localMemberships[membership{u.Name, g}] = true
+		}
+	}
+	remoteMemberships := map[membership]bool{}
+	for _, u := range remote.Users {
+		for _, g := range u.Groups {
+			remoteMemberships[membership{u.Name, g}] = true
+		}
+	}
+
+	for m := range localMemberships {
+		if !remoteMemberships[m] {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: "group-membership", Path: m.user, Name: m.group})
+		}
+	}
+	for m := range remoteMemberships {
+		if !localMemberships[m] {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: "group-membership", Path: m.user, Name: m.group})
+		}
+	}
+}
+
+// planPolicyAttachments diffs one principal's managed policy attachments.
+// The attachment's principal is carried in Path and the attached policy
+// ARN in Name, since an attachment doesn't have an IAM path of its own.
+func planPolicyAttachments(plan *Plan, resourceType, principalName string, localPolicies, remotePolicies []string) {
+	attachmentType := resourceType + "-policy-attachment"
+
+	for _, policyArn := range localPolicies {
+		if !contains(remotePolicies, policyArn) {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, Type: attachmentType, Path: principalName, Name: policyArn})
+		}
+	}
+	for _, policyArn := range remotePolicies {
+		if !contains(localPolicies, policyArn) {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Type: attachmentType, Path: principalName, Name: policyArn})
+		}
+	}
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// diffPolicyDocuments reports whether two policy documents differ once
+// normalised, along with a human-readable diff of that normalised form.
+// Normalising sorts statements so that reordering them doesn't register as
+// a change.
+func diffPolicyDocuments(before, after iamy.PolicyDocument) (string, bool, error) {
+	beforeNorm, err := normalisePolicyDocument(before)
+	if err != nil {
+		return "", false, err
+	}
+	afterNorm, err := normalisePolicyDocument(after)
+	if err != nil {
+		return "", false, err
+	}
+
+	if beforeNorm == afterNorm {
+		return "", false, nil
+	}
+	return lineDiff(beforeNorm, afterNorm), true, nil
+}
+
+func normalisePolicyDocument(doc iamy.PolicyDocument) (string, error) {
+	if doc == nil {
+		return "", nil
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return "", err
+	}
+
+	if stmts, ok := generic["Statement"].([]interface{}); ok {
+		sort.Slice(stmts, func(i, j int) bool {
+			bi, _ := json.Marshal(stmts[i])
+			bj, _ := json.Marshal(stmts[j])
+			return string(bi) < string(bj)
+		})
+		generic["Statement"] = stmts
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(generic); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// lineDiff is a minimal, dependency-free diff: it reports lines present in
+// one side but not the other, prefixed `-`/`+` like a unified diff. It
+// doesn't try to find a minimal edit script.
+func lineDiff(before, after string) string {
+	beforeLines := strings.Split(before, "\n")
+	afterLines := strings.Split(after, "\n")
+
+	remaining := map[string]int{}
+	for _, l := range afterLines {
+		remaining[l]++
+	}
+
+	var buf bytes.Buffer
+	for _, l := range beforeLines {
+		if remaining[l] > 0 {
+			remaining[l]--
+			continue
+		}
+		fmt.Fprintf(&buf, "-%s\n", l)
+	}
+
+	remaining = map[string]int{}
+	for _, l := range beforeLines {
+		remaining[l]++
+	}
+	for _, l := range afterLines {
+		if remaining[l] > 0 {
+			remaining[l]--
+			continue
+		}
+		fmt.Fprintf(&buf, "+%s\n", l)
+	}
+
+	return buf.String()
+}
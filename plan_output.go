@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// printPlanJson writes a Plan to ui in the stable `--output json` schema.
+func printPlanJson(ui Ui, plan Plan) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	ui.Println(string(b))
+	return nil
+}
+
+// printPlanText writes a Plan to ui in a Terraform-plan-style human
+// readable format: one `+`/`-`/`~` line per change.
+func printPlanText(ui Ui, plan Plan) {
+	if len(plan.Changes) == 0 {
+		ui.Printf("No changes. %s is up to date.\n", plan.Account)
+		return
+	}
+
+	ui.Printf("Plan for %s:\n\n", plan.Account)
+
+	var creates, updates, deletes int
+	for _, c := range plan.Changes {
+		ui.Println(planChangeLine(c))
+		if c.PolicyDiff != "" {
+			ui.Println(indent(c.PolicyDiff))
+		}
+
+		switch c.Action {
+		case ActionCreate:
+			creates++
+		case ActionUpdate:
+			updates++
+		case ActionDelete:
+			deletes++
+		}
+	}
+
+	ui.Printf("\nPlan: %d to create, %d to update, %d to delete.\n", creates, updates, deletes)
+}
+
+func planChangeLine(c Change) string {
+	symbol := map[ChangeAction]string{
+		ActionCreate: "+",
+		ActionUpdate: "~",
+		ActionDelete: "-",
+	}[c.Action]
+
+	return fmt.Sprintf("  %s %s %s", symbol, c.Type, c.Name)
+}
+
+func indent(s string) string {
+	out := ""
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		out += "      " + line + "\n"
+	}
+	return out
+}
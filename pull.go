@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/session"
+	"github.com/99designs/iamy/iamy"
+)
+
+type PullCommandInput struct {
+	Dir             string
+	CanDelete       bool
+	AttachmentStyle string
+	PolicyFormat    string
+}
+
+// PullCommand fetches the current IAM state of the active AWS account and
+// dumps it to YAML files under Dir.
+func PullCommand(ui Ui, input PullCommandInput) {
+	sess, err := session.NewSession()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+	client := iamy.NewAWSIAMClient(sess)
+
+	if err := pullAccount(client, input); err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+}
+
+// pullAccount fetches the IAM state visible to client and dumps it to YAML
+// files under input.Dir. It's shared by PullCommand and OrgPullCommand, which
+// differ only in how the AWSIAMClient and Dir are arrived at.
+func pullAccount(client iamy.AWSIAMClient, input PullCommandInput) error {
+	account, err := client.DescribeAccount()
+	if err != nil {
+		return err
+	}
+
+	ad, err := client.FetchAccountData(account)
+	if err != nil {
+		return err
+	}
+
+	if input.AttachmentStyle == "separate" {
+		ad.ExtractAttachments()
+	}
+
+	dumper := iamy.Yaml
+	dumper.Dir = input.Dir
+	dumper.PolicyFormat = input.PolicyFormat
+
+	return dumper.Dump(ad, input.CanDelete)
+}
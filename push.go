@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/99designs/iamy/Godeps/_workspace/src/github.com/aws/aws-sdk-go/aws/session"
+	"github.com/99designs/iamy/iamy"
+	"github.com/99designs/iamy/iamy/policycheck"
+)
+
+// errValidationFailed is returned by pushAccount when policy validation
+// aborts the push. runValidation has already printed the violations, so
+// callers should exit without printing this error too.
+var errValidationFailed = errors.New("policy validation failed")
+
+type PushCommandInput struct {
+	Dir          string
+	SkipValidate bool
+	DryRun       bool
+	Output       string
+}
+
+// PushCommand loads the YAML IAM data under Dir and reconciles the active
+// AWS account to match it, creating, updating and deleting users, groups,
+// roles and policies as needed. With DryRun set, it prints the plan and
+// exits without calling any mutating IAM API. Dir may hold more than one
+// {alias}-{id} account directory; only the one matching the authenticated
+// account is reconciled.
+func PushCommand(ui Ui, input PushCommandInput) {
+	loader := iamy.Yaml
+	loader.Dir = input.Dir
+
+	localAccounts, err := loader.Load()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+	client := iamy.NewAWSIAMClient(sess)
+
+	account, err := client.DescribeAccount()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	local, err := findLocalAccount(localAccounts, account.Id)
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	if err := pushAccount(ui, client, *local, input); err != nil {
+		if err != errValidationFailed {
+			ui.Error.Println(err)
+		}
+		ui.Exit(1)
+		return
+	}
+}
+
+// findLocalAccount returns the AccountData in accounts whose Account.Id
+// matches id, so that push only ever reconciles the one local account
+// directory that corresponds to the AWS account it's authenticated against.
+func findLocalAccount(accounts []iamy.AccountData, id string) (*iamy.AccountData, error) {
+	for i := range accounts {
+		if accounts[i].Account.Id == id {
+			return &accounts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no account directory for %s found under the given dir", id)
+}
+
+// pushAccount reconciles a single local AccountData against the AWS account
+// that client is authenticated against. It's shared by PushCommand and
+// OrgPushCommand, which differ only in how the AWSIAMClient and local
+// AccountData are arrived at.
+func pushAccount(ui Ui, client iamy.AWSIAMClient, local iamy.AccountData, input PushCommandInput) error {
+	if !input.SkipValidate {
+		if abort := runValidation(ui, local); abort {
+			return errValidationFailed
+		}
+	}
+
+	remote, err := client.FetchAccountData(local.Account)
+	if err != nil {
+		return err
+	}
+
+	plan, err := (Planner{}).Plan(local, *remote)
+	if err != nil {
+		return err
+	}
+
+	if input.Output == "json" {
+		if err := printPlanJson(ui, plan); err != nil {
+			return err
+		}
+	} else {
+		printPlanText(ui, plan)
+	}
+
+	if input.DryRun {
+		return nil
+	}
+
+	return applyPlan(client, local.Account, plan)
+}
+
+// runValidation runs the default policycheck ruleset against an account's
+// policy documents, printing any violations found. It returns true if the
+// push should be aborted.
+func runValidation(ui Ui, ad iamy.AccountData) bool {
+	validator, err := buildValidator("")
+	if err != nil {
+		ui.Error.Println(err)
+		return true
+	}
+
+	violations, err := validator.Validate(ad)
+	if err != nil {
+		ui.Error.Println(err)
+		return true
+	}
+
+	if len(violations) == 0 {
+		return false
+	}
+
+	for _, v := range violations {
+		ui.Error.Println(v.String())
+	}
+	ui.Error.Printf("%d policy violation(s) found in %s, aborting push. Pass --skip-validate to push anyway.\n", len(violations), ad.Account)
+
+	return true
+}
+
+// buildValidator returns a policycheck.Validator using the ruleset at
+// rulesFile, or policycheck.DefaultRuleset if rulesFile is empty.
+func buildValidator(rulesFile string) (*policycheck.Validator, error) {
+	if rulesFile == "" {
+		return policycheck.NewDefaultValidator()
+	}
+
+	rulesYaml, err := ioutil.ReadFile(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return policycheck.NewValidator(rulesYaml)
+}
+
+// applyPlan turns a Plan's changes into the IAM API calls needed to carry
+// them out.
+func applyPlan(client iamy.AWSIAMClient, account *iamy.Account, plan Plan) error {
+	for _, c := range plan.Changes {
+		if err := applyChange(client, account, c); err != nil {
+			return fmt.Errorf("applying %s %s %s: %s", c.Action, c.Type, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func applyChange(client iamy.AWSIAMClient, account *iamy.Account, c Change) error {
+	switch c.Type {
+	case "user":
+		return applyUserChange(client, c)
+	case "group":
+		return applyGroupChange(client, c)
+	case "role":
+		return applyRoleChange(client, c)
+	case "policy":
+		return applyPolicyChange(client, account, c)
+	case "user-inline-policy", "group-inline-policy", "role-inline-policy":
+		return applyInlinePolicyChange(client, c)
+	case "group-membership":
+		return applyGroupMembershipChange(client, c)
+	case "user-policy-attachment", "group-policy-attachment", "role-policy-attachment":
+		return applyPolicyAttachmentChange(client, c)
+	default:
+		return fmt.Errorf("unknown change type %q", c.Type)
+	}
+}
+
+func applyUserChange(client iamy.AWSIAMClient, c Change) error {
+	switch c.Action {
+	case ActionCreate:
+		return client.CreateUser(c.Path, c.Name)
+	case ActionDelete:
+		return client.DeleteUser(c.Name)
+	}
+	return nil
+}
+
+func applyGroupChange(client iamy.AWSIAMClient, c Change) error {
+	switch c.Action {
+	case ActionCreate:
+		return client.CreateGroup(c.Path, c.Name)
+	case ActionDelete:
+		return client.DeleteGroup(c.Name)
+	}
+	return nil
+}
+
+func applyRoleChange(client iamy.AWSIAMClient, c Change) error {
+	switch c.Action {
+	case ActionCreate:
+		return client.CreateRole(c.Path, c.Name, c.After.(iamy.Role).AssumeRolePolicyDocument)
+	case ActionUpdate:
+		return client.UpdateAssumeRolePolicy(c.Name, c.After.(iamy.PolicyDocument))
+	case ActionDelete:
+		return client.DeleteRole(c.Name)
+	}
+	return nil
+}
+
+func applyPolicyChange(client iamy.AWSIAMClient, account *iamy.Account, c Change) error {
+	switch c.Action {
+	case ActionCreate:
+		return client.CreatePolicy(c.Path, c.Name, c.After.(iamy.Policy).Policy)
+	case ActionUpdate:
+		arn := iamy.Arn(iamy.Policy{Name: c.Name, Path: c.Path}, account)
+		return client.UpdatePolicy(arn, c.After.(iamy.PolicyDocument))
+	case ActionDelete:
+		arn := iamy.Arn(iamy.Policy{Name: c.Name, Path: c.Path}, account)
+		return client.DeletePolicy(arn)
+	}
+	return nil
+}
+
+func applyInlinePolicyChange(client iamy.AWSIAMClient, c Change) error {
+	principalName, policyName, err := splitPrincipalAndName(c.Name)
+	if err != nil {
+		return err
+	}
+
+	switch c.Type {
+	case "user-inline-policy":
+		if c.Action == ActionDelete {
+			return client.DeleteUserPolicy(principalName, policyName)
+		}
+		return client.PutUserPolicy(principalName, policyName, c.After.(iamy.PolicyDocument))
+	case "group-inline-policy":
+		if c.Action == ActionDelete {
+			return client.DeleteGroupPolicy(principalName, policyName)
+		}
+		return client.PutGroupPolicy(principalName, policyName, c.After.(iamy.PolicyDocument))
+	case "role-inline-policy":
+		if c.Action == ActionDelete {
+			return client.DeleteRolePolicy(principalName, policyName)
+		}
+		return client.PutRolePolicy(principalName, policyName, c.After.(iamy.PolicyDocument))
+	}
+	return nil
+}
+
+func applyGroupMembershipChange(client iamy.AWSIAMClient, c Change) error {
+	userName, groupName := c.Path, c.Name
+	if c.Action == ActionDelete {
+		return client.RemoveUserFromGroup(userName, groupName)
+	}
+	return client.AddUserToGroup(userName, groupName)
+}
+
+func applyPolicyAttachmentChange(client iamy.AWSIAMClient, c Change) error {
+	principalName, policyArn := c.Path, c.Name
+
+	switch c.Type {
+	case "user-policy-attachment":
+		if c.Action == ActionDelete {
+			return client.DetachUserPolicy(principalName, policyArn)
+		}
+		return client.AttachUserPolicy(principalName, policyArn)
+	case "group-policy-attachment":
+		if c.Action == ActionDelete {
+			return client.DetachGroupPolicy(principalName, policyArn)
+		}
+		return client.AttachGroupPolicy(principalName, policyArn)
+	case "role-policy-attachment":
+		if c.Action == ActionDelete {
+			return client.DetachRolePolicy(principalName, policyArn)
+		}
+		return client.AttachRolePolicy(principalName, policyArn)
+	}
+	return nil
+}
+
+func splitPrincipalAndName(s string) (string, string, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected <principal>/<name>, got %q", s)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/99designs/iamy/iamy"
+)
+
+type ValidateCommandInput struct {
+	Dir   string
+	Rules string
+}
+
+// ValidateCommand runs policycheck's rule engine against the policy
+// documents found in the YAML files under Dir, without talking to AWS. It's
+// the standalone counterpart to push's built-in validation pass, for
+// linting a tree (e.g. in CI) before anyone tries to push it.
+func ValidateCommand(ui Ui, input ValidateCommandInput) {
+	loader := iamy.Yaml
+	loader.Dir = input.Dir
+
+	localAccounts, err := loader.Load()
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	validator, err := buildValidator(input.Rules)
+	if err != nil {
+		ui.Error.Println(err)
+		ui.Exit(1)
+		return
+	}
+
+	failed := false
+	for _, ad := range localAccounts {
+		violations, err := validator.Validate(ad)
+		if err != nil {
+			ui.Error.Println(err)
+			ui.Exit(1)
+			return
+		}
+
+		for _, v := range violations {
+			ui.Error.Println(v.String())
+		}
+		if len(violations) > 0 {
+			failed = true
+		}
+	}
+
+	if failed {
+		ui.Exit(1)
+		return
+	}
+
+	ui.Println("No policy violations found.")
+}